@@ -1,12 +1,19 @@
-// Package docker integrates with the Docker Remote API https://docs.docker.com/reference/api/docker_remote_api_v1.20/
+// Package docker integrates with Docker via the official Docker Engine API client.
 package docker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/fsouza/go-dockerclient"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
 	"github.com/op/go-logging"
 
 	"github.com/microscaling/microscaling/demand"
@@ -15,63 +22,233 @@ import (
 
 const labelMap string = "com.microscaling.microscaling-in-a-box"
 
+// defaultMaxConcurrentScaleOps bounds how many CreateContainer/StopContainer
+// operations we'll have in flight at once, so a big demand spike doesn't fire
+// hundreds of simultaneous RPCs at the Docker daemon.
+const defaultMaxConcurrentScaleOps = 8
+
 var log = logging.MustGetLogger("mssscheduler")
 
 type dockerContainer struct {
-	state   string
-	updated bool
+	state           string
+	updated         bool
+	health          string // Docker health-check status: "", "starting", "healthy" or "unhealthy"
+	restartCount    int
+	lastTermination *termination
+	memoryReserved  int64 // memory claimed from reserveMemory for this container, 0 once released
+	autoRestarts    bool  // true if Docker restarts this container itself on death (task.RestartPolicy set and not "no")
+}
+
+// termination records how a container last exited, so we can tell a deliberate
+// stop from a crash when deciding what to do next.
+type termination struct {
+	exitCode   int
+	reason     string
+	finishedAt time.Time
+}
+
+// TaskStatus is the aggregated restart/health state for a task, so callers can
+// see whether containers are flapping without walking our internal container map.
+type TaskStatus struct {
+	Healthy      int
+	Unhealthy    int
+	RestartCount int
+	LastExitCode int
 }
 
 // DockerScheduler stores information and state we need for communicating with Docker remote API
 // We keep track of each container so that we have their identities to stop them when we need to
 type DockerScheduler struct {
-	client         *docker.Client
-	pullImages     bool
-	taskContainers map[string]map[string]*dockerContainer // tasks indexed by app name, containers indexed by ID
-	sync.Mutex
+	client            *client.Client
+	pullImages        bool
+	taskContainers    map[string]map[string]*dockerContainer // tasks indexed by app name, containers indexed by ID
+	restartCounts     map[string]int                         // restart counts keyed by "taskName/id", persisted to restartCountsPath so they survive a scheduler restart
+	restartCountsPath string                                 // where restartCounts is loaded from and saved to
+	restartCountsMu   sync.Mutex                             // serializes concurrent saveRestartCounts writers
+	needsRefresh      map[string]bool                        // "taskName/id" pending a full ContainerInspect after an event
+	closed            chan struct{}                          // closed to shut down eventLoop
+	hostMemory        int64                                   // total memory reported by the Docker host, 0 if unknown
+	committedMemory   int64                                   // memory committed to running/starting containers
+	scaleSem          chan struct{}                           // bounds concurrent scale operations
+	scaleWG           sync.WaitGroup                          // tracks all scale operations in flight, for Shutdown
+	taskLocks         map[string]*sync.Mutex                  // serializes stop/start of the same task
+	authProvider      RegistryAuthProvider                    // resolves per-registry pull credentials
+	sync.RWMutex
 }
 
-// NewScheduler creates a new interface to the Docker remote API
+// NewScheduler creates a new interface to the Docker Engine API
 func NewScheduler(pullImages bool, dockerHost string) *DockerScheduler {
-	client, err := docker.NewClient(dockerHost)
+	cli, err := client.NewClientWithOpts(client.WithHost(dockerHost), client.WithAPIVersionNegotiation())
 	if err != nil {
 		log.Errorf("Error starting Docker client: %v", err)
 		return nil
 	}
 
-	return &DockerScheduler{
-		client:         client,
-		taskContainers: make(map[string]map[string]*dockerContainer),
-		pullImages:     pullImages,
+	c := &DockerScheduler{
+		client:            cli,
+		taskContainers:    make(map[string]map[string]*dockerContainer),
+		restartCounts:     make(map[string]int),
+		restartCountsPath: defaultRestartCountsPath,
+		needsRefresh:      make(map[string]bool),
+		closed:            make(chan struct{}),
+		scaleSem:          make(chan struct{}, defaultMaxConcurrentScaleOps),
+		taskLocks:         make(map[string]*sync.Mutex),
+		authProvider:      NewRegistryAuthProvider(),
+		pullImages:        pullImages,
 	}
+
+	if info, err := cli.Info(context.Background()); err != nil {
+		log.Errorf("Couldn't get Docker host info, memory-aware scale-up is disabled: %v", err)
+	} else {
+		c.hostMemory = info.MemTotal
+	}
+
+	c.loadRestartCounts()
+
+	go c.eventLoop()
+
+	return c
 }
 
-// compile-time assert that we implement the right interface
+// compile-time assert that we implement the right interface. StopStartTasks
+// below takes a ctx so Shutdown can cancel scale ops still queued on the
+// semaphore - that's only valid if scheduler.Scheduler's method was updated
+// to match in the same series; this package doesn't vendor that interface,
+// so there's nothing else here to change in lockstep with it.
 var _ scheduler.Scheduler = (*DockerScheduler)(nil)
 
-var scaling sync.WaitGroup
+// SetRegistryAuthProvider overrides how pull credentials are resolved. It must
+// be called before InitScheduler pulls an image that needs it.
+func (c *DockerScheduler) SetRegistryAuthProvider(p RegistryAuthProvider) {
+	c.Lock()
+	defer c.Unlock()
+	c.authProvider = p
+}
+
+// SetMaxConcurrentScaleOps configures how many CreateContainer/StopContainer
+// operations StopStartTasks will run at once. Call it right after NewScheduler;
+// operations already in flight against the previous limit are unaffected.
+func (c *DockerScheduler) SetMaxConcurrentScaleOps(n int) {
+	if n <= 0 {
+		n = defaultMaxConcurrentScaleOps
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.scaleSem = make(chan struct{}, n)
+}
+
+// SetRestartCountsPath overrides where restart counts are persisted. Call it
+// right after NewScheduler, before any tasks are running, since it reloads
+// whatever's already on disk at the new path.
+func (c *DockerScheduler) SetRestartCountsPath(path string) {
+	c.Lock()
+	c.restartCountsPath = path
+	c.Unlock()
+
+	c.loadRestartCounts()
+}
+
+// errScaleOpSkipped is returned by runScaleOp when ctx is done before fn ever
+// ran, so callers can tell that apart from fn's own errors - including one
+// that happens to wrap ctx.Err() itself, e.g. a cancelled ContainerCreate.
+var errScaleOpSkipped = errors.New("scale operation skipped: context done before a slot was available")
+
+// runScaleOp runs fn under the scale-op semaphore, serialized against any other
+// in-flight operation for the same task so a stop and a start of the same task
+// never race. It returns errScaleOpSkipped if ctx is done before a semaphore
+// slot frees up, without ever calling fn.
+func (c *DockerScheduler) runScaleOp(ctx context.Context, taskName string, fn func() error) error {
+	c.scaleWG.Add(1)
+	defer c.scaleWG.Done()
+
+	c.Lock()
+	sem := c.scaleSem
+	c.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return errScaleOpSkipped
+	}
+	defer func() { <-sem }()
+
+	lock := c.taskLock(taskName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn()
+}
+
+func (c *DockerScheduler) taskLock(name string) *sync.Mutex {
+	c.Lock()
+	defer c.Unlock()
+
+	lock, ok := c.taskLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.taskLocks[name] = lock
+	}
+	return lock
+}
+
+// Shutdown waits for all in-flight scale operations to finish, or for ctx to
+// be done, whichever comes first.
+func (c *DockerScheduler) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.scaleWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
 // InitScheduler gets the images for each task
 func (c *DockerScheduler) InitScheduler(task *demand.Task) (err error) {
 	log.Infof("Docker initializing task %s", task.Name)
 
+	if err = validateTask(task); err != nil {
+		log.Errorf("Task %s has an invalid spec: %v", task.Name, err)
+		return err
+	}
+
 	c.Lock()
 	defer c.Unlock()
 
 	c.taskContainers[task.Name] = make(map[string]*dockerContainer, 100)
 
-	// We may need to pull the image for this container
-	if c.pullImages {
-		pullOpts := docker.PullImageOptions{
-			Repository: task.Image,
-		}
+	// We may need to pull the image for this container. AlwaysPull lets a
+	// security-sensitive task re-verify its image even when pullImages is
+	// globally false; digest-pinned references (image@sha256:...) work the
+	// same way here - ImagePull resolves them without any special casing.
+	if c.pullImages || task.AlwaysPull {
+		log.Infof("Pulling image: %v", task.Image)
 
-		authOpts := docker.AuthConfiguration{}
+		pullOpts := types.ImagePullOptions{}
+		if auth, authErr := c.authProvider.AuthForImage(context.Background(), task.Image); authErr != nil {
+			log.Errorf("Couldn't resolve pull credentials for %s, trying anonymously: %v", task.Image, authErr)
+		} else if encoded, encErr := encodeAuth(auth); encErr != nil {
+			log.Errorf("Couldn't encode pull credentials for %s: %v", task.Image, encErr)
+		} else {
+			pullOpts.RegistryAuth = encoded
+		}
 
-		log.Infof("Pulling image: %v", task.Image)
-		err = c.client.PullImage(pullOpts, authOpts)
+		reader, err := c.client.ImagePull(context.Background(), task.Image, pullOpts)
 		if err != nil {
 			log.Errorf("Failed to pull image %s: %v", task.Image, err)
+			return err
+		}
+		defer reader.Close()
+
+		// Drain the pull progress stream - we don't surface it yet.
+		if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+			log.Errorf("Failed to pull image %s: %v", task.Image, err)
 		}
 	}
 
@@ -79,121 +256,109 @@ func (c *DockerScheduler) InitScheduler(task *demand.Task) (err error) {
 }
 
 // startTask creates the container and then starts it
-func (c *DockerScheduler) startTask(task *demand.Task) {
-	var labels = map[string]string{
-		labelMap: task.Name,
-	}
+func (c *DockerScheduler) startTask(ctx context.Context, task *demand.Task) error {
+	config, hostConfig := taskSpec(task)
 
-	var cmds = strings.Fields(task.Command)
-
-	createOpts := docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Image:        task.Image,
-			Cmd:          cmds,
-			AttachStdout: true,
-			AttachStdin:  true,
-			Labels:       labels,
-			Env:          task.Env,
-		},
-		HostConfig: &docker.HostConfig{
-			PublishAllPorts: task.PublishAllPorts,
-			NetworkMode:     task.NetworkMode,
-		},
+	log.Debugf("[start] task %s", task.Name)
+	created, err := c.client.ContainerCreate(ctx, config, hostConfig, nil, "")
+	if err != nil {
+		log.Errorf("Couldn't create container for task %s: %v", task.Name, err)
+		// The caller already reserved task.MemoryLimit for this container; since
+		// none was ever created, nothing else will release it.
+		c.releaseMemory(task.MemoryLimit)
+		return err
 	}
 
-	go func() {
-		scaling.Add(1)
-		defer scaling.Done()
-
-		log.Debugf("[start] task %s", task.Name)
-		container, err := c.client.CreateContainer(createOpts)
-		if err != nil {
-			log.Errorf("Couldn't create container for task %s: %v", task.Name, err)
-			return
-		}
-
-		var containerID = container.ID[:12]
+	var containerID = created.ID[:12]
 
+	c.Lock()
+	c.taskContainers[task.Name][containerID] = &dockerContainer{
+		state:          "created",
+		memoryReserved: task.MemoryLimit,
+		autoRestarts:   task.RestartPolicy != "" && task.RestartPolicy != "no",
+	}
+	c.Unlock()
+	log.Debugf("[created] task %s ID %s", task.Name, containerID)
+
+	if err = c.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
+		log.Errorf("Couldn't start container ID %s for task %s: %v", containerID, task.Name, err)
+		// The container exists but never reached running state, and CountAllTasks
+		// lists running containers only, so it'll never observe this one to
+		// release its reservation - do it here instead.
+		c.releaseContainerMemory(task.Name, containerID)
 		c.Lock()
-		c.taskContainers[task.Name][containerID] = &dockerContainer{
-			state: "created",
-		}
+		delete(c.taskContainers[task.Name], containerID)
 		c.Unlock()
-		log.Debugf("[created] task %s ID %s", task.Name, containerID)
+		return err
+	}
 
-		// Start it but passing nil for the HostConfig as this option was removed in Docker 1.12.
-		err = c.client.StartContainer(containerID, nil)
-		if err != nil {
-			log.Errorf("Couldn't start container ID %s for task %s: %v", containerID, task.Name, err)
-			return
-		}
+	log.Debugf("[starting] task %s ID %s", task.Name, containerID)
 
-		log.Debugf("[starting] task %s ID %s", task.Name, containerID)
+	c.Lock()
+	c.taskContainers[task.Name][containerID].state = "starting"
+	c.Unlock()
 
-		c.Lock()
-		c.taskContainers[task.Name][containerID].state = "starting"
-		c.Unlock()
-	}()
+	return nil
 }
 
 // stopTask kills the last container we know about of this type
-func (c *DockerScheduler) stopTask(task *demand.Task) error {
-	var err error
-
-	// Kill a currently-running container of this type
+func (c *DockerScheduler) stopTask(ctx context.Context, task *demand.Task) error {
+	// Kill a currently-running container of this type. Prefer an unhealthy one: if the
+	// health check says a container is already in trouble, replacing it does more good
+	// than killing an arbitrary healthy one.
 	c.Lock()
 	theseContainers := c.taskContainers[task.Name]
 	var containerToKill string
 	for id, v := range theseContainers {
-		if v.state == "running" {
+		if v.state == "running" && v.health == "unhealthy" {
 			containerToKill = id
 			v.state = "stopping"
 			break
 		}
 	}
+	if containerToKill == "" {
+		for id, v := range theseContainers {
+			if v.state == "running" {
+				containerToKill = id
+				v.state = "stopping"
+				break
+			}
+		}
+	}
 	c.Unlock()
 
 	if containerToKill == "" {
 		return fmt.Errorf("[stop] No containers of type %s to kill", task.Name)
 	}
 
-	removeOpts := docker.RemoveContainerOptions{
-		ID:            containerToKill,
-		RemoveVolumes: true,
+	log.Debugf("[stopping] container for task %s with ID %s", task.Name, containerToKill)
+	timeout := 1 * time.Second
+	if err := c.client.ContainerStop(ctx, containerToKill, &timeout); err != nil {
+		log.Errorf("Couldn't stop container %s: %v", containerToKill, err)
+		return err
 	}
 
-	go func() {
-		scaling.Add(1)
-		defer scaling.Done()
-
-		log.Debugf("[stopping] container for task %s with ID %s", task.Name, containerToKill)
-		err = c.client.StopContainer(containerToKill, 1)
-		if err != nil {
-			log.Errorf("Couldn't stop container %s: %v", containerToKill, err)
-			return
-		}
-
-		c.Lock()
-		c.taskContainers[task.Name][containerToKill].state = "removing"
-		c.Unlock()
+	c.Lock()
+	c.taskContainers[task.Name][containerToKill].state = "removing"
+	c.Unlock()
 
-		log.Debugf("[removing] container for task %s with ID %s", task.Name, containerToKill)
-		err = c.client.RemoveContainer(removeOpts)
-		if err != nil {
-			log.Errorf("Couldn't remove container %s: %v", containerToKill, err)
-			return
-		}
-	}()
+	log.Debugf("[removing] container for task %s with ID %s", task.Name, containerToKill)
+	if err := c.client.ContainerRemove(ctx, containerToKill, types.ContainerRemoveOptions{RemoveVolumes: true}); err != nil {
+		log.Errorf("Couldn't remove container %s: %v", containerToKill, err)
+		return err
+	}
 
+	c.releaseContainerMemory(task.Name, containerToKill)
 	return nil
 }
 
-// StopStartTasks creates containers if there aren't enough of them, and stop them if there are too many
-func (c *DockerScheduler) StopStartTasks(tasks *demand.Tasks) error {
+// StopStartTasks creates containers if there aren't enough of them, and stop them if there are too many.
+// ctx bounds the whole batch: if it's cancelled, operations still queued on the scale-op
+// semaphore return early rather than start.
+func (c *DockerScheduler) StopStartTasks(ctx context.Context, tasks *demand.Tasks) error {
 	var tooMany []*demand.Task
 	var tooFew []*demand.Task
 	var diff int
-	var err error
 
 	tasks.Lock()
 	defer tasks.Unlock()
@@ -212,32 +377,70 @@ func (c *DockerScheduler) StopStartTasks(tasks *demand.Tasks) error {
 		}
 	}
 
+	var batch sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	addErr := func(err error) {
+		if err == nil {
+			return
+		}
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
 	// Scale down first to free up resources
 	for _, task := range tooMany {
 		diff = task.Requested - task.Demand
 		log.Infof("Stop %d of task %s", diff, task.Name)
 		for i := 0; i < diff; i++ {
-			err = c.stopTask(task)
-			if err != nil {
-				log.Errorf("Couldn't stop %s: %v ", task.Name, err)
-			}
+			task := task
 			task.Requested--
+
+			batch.Add(1)
+			go func() {
+				defer batch.Done()
+				if err := c.runScaleOp(ctx, task.Name, func() error { return c.stopTask(ctx, task) }); err != nil {
+					log.Errorf("Couldn't stop %s: %v", task.Name, err)
+					addErr(err)
+				}
+			}()
 		}
 	}
 
-	// Now we can scale up
+	// Now we can scale up, as long as the host has room for it
 	for _, task := range tooFew {
 		diff = task.Demand - task.Requested
 		log.Infof("Start %d of task %s", diff, task.Name)
 		for i := 0; i < diff; i++ {
-			c.startTask(task)
+			if !c.reserveMemory(task.MemoryLimit) {
+				log.Errorf("Not enough memory on the host to start another %s, skipping scale-up", task.Name)
+				break
+			}
+
+			task := task
 			task.Requested++
+
+			batch.Add(1)
+			go func() {
+				defer batch.Done()
+				if err := c.runScaleOp(ctx, task.Name, func() error { return c.startTask(ctx, task) }); err != nil {
+					log.Errorf("Couldn't start %s: %v", task.Name, err)
+					if errors.Is(err, errScaleOpSkipped) {
+						// startTask never ran, so the memory we reserved above
+						// was never claimed by (or released for) a container.
+						c.releaseMemory(task.MemoryLimit)
+					}
+					addErr(err)
+				}
+			}()
 		}
 	}
 
-	// Don't return until all the scale tasks are complete
-	scaling.Wait()
-	return err
+	// Don't return until all the scale operations from this batch are complete
+	batch.Wait()
+	return errors.Join(errs...)
 }
 
 func statusToState(status string) string {
@@ -257,21 +460,38 @@ func statusToState(status string) string {
 	return "unknown"
 }
 
-// CountAllTasks checks how many of each task are running
+// CountAllTasks is now a reconciliation pass rather than the primary source of
+// truth: eventLoop keeps c.taskContainers up to date as events arrive, and this
+// just double-checks against a full container listing and picks up anything
+// queued in needsRefresh.
 func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
-	// Docker Remote API https://docs.docker.com/reference/api/docker_remote_api_v1.20/
-	// get /containers/json
 	var err error
-	var containers []docker.APIContainers
-	containers, err = c.client.ListContainers(docker.ListContainersOptions{})
+
+	// Push the label filter server-side instead of listing every container
+	// and filtering client-side.
+	listOpts := types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelMap)),
+	}
+	containers, err := c.client.ContainerList(context.Background(), listOpts)
 	if err != nil {
 		return fmt.Errorf("Failed to list containers: %v", err)
 	}
 
 	running.Lock()
 	defer running.Unlock()
+
+	// healthRefresh is a container we've decided needs a ContainerInspect to
+	// pick up its health/restart/termination state. We collect these while
+	// c's lock is held below, then inspect them once it's released, so a full
+	// reconciliation pass over N containers doesn't block handleEvent behind
+	// N serial RPCs.
+	type healthRefresh struct {
+		task *demand.Task
+		id   string
+	}
+	var pending []healthRefresh
+
 	c.Lock()
-	defer c.Unlock()
 
 	// Reset all the running counts to 0
 	tasks := running.Tasks
@@ -302,6 +522,12 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 				if !ok {
 					log.Infof("We have no previous record of container %s, state %s", id, newState)
 					thisContainer = &dockerContainer{}
+					if rc, ok := c.restartCounts[taskName+"/"+id]; ok {
+						// This container ID was already running before a scheduler
+						// restart - restore its restart count rather than starting
+						// back at 0.
+						thisContainer.restartCount = rc
+					}
 					c.taskContainers[taskName][id] = thisContainer
 				}
 
@@ -312,6 +538,7 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 					if thisContainer.state == "starting" || thisContainer.state == "" {
 						thisContainer.state = newState
 					}
+					pending = append(pending, healthRefresh{t, id})
 				case "removing":
 					if thisContainer.state != "removing" {
 						log.Errorf("Container %s is being removed, but we didn't terminate it", id)
@@ -319,6 +546,14 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 				case "exited":
 					if thisContainer.state != "stopping" && thisContainer.state != "exited" {
 						log.Errorf("Container %s is being removed, but we didn't terminate it", id)
+						if !thisContainer.autoRestarts {
+							// It crashed on its own rather than going through
+							// stopTask or replaceUnhealthy, so nothing else will
+							// release its memory reservation. A restart-policy
+							// container keeps its reservation - Docker is about
+							// to bring the same container straight back up.
+							c.releaseReservation(thisContainer)
+						}
 					}
 				case "dead":
 					if thisContainer.state != "dead" {
@@ -328,10 +563,17 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 				}
 
 				thisContainer.updated = true
+
+				refreshKey := taskName + "/" + id
+				if c.needsRefresh[refreshKey] {
+					pending = append(pending, healthRefresh{t, id})
+					delete(c.needsRefresh, refreshKey)
+				}
 			}
 		}
 	}
 
+	deletedRestartCounts := false
 	for _, task := range tasks {
 		log.Debugf("  %s: internally running %d, requested %d", task.Name, task.Running, task.Requested)
 		for id, cc := range c.taskContainers[task.Name] {
@@ -339,7 +581,10 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 			if !cc.updated {
 				if cc.state == "removing" || cc.state == "exited" {
 					log.Debugf("    Deleting %s", id)
+					c.releaseReservation(cc)
 					delete(c.taskContainers[task.Name], id)
+					delete(c.restartCounts, task.Name+"/"+id)
+					deletedRestartCounts = true
 				} else if cc.state != "created" && cc.state != "starting" && cc.state != "stopping" {
 					log.Errorf("Bad state for container %s: %s", id, cc.state)
 				}
@@ -347,10 +592,137 @@ func (c *DockerScheduler) CountAllTasks(running *demand.Tasks) error {
 		}
 	}
 
+	c.Unlock()
+
+	if deletedRestartCounts {
+		go c.saveRestartCounts()
+	}
+
+	// The ContainerInspect RPCs in refreshHealth happen without c's lock held,
+	// so they don't block handleEvent's incremental updates behind however
+	// many containers we just reconciled.
+	for _, r := range pending {
+		c.refreshHealth(r.task, r.id)
+	}
+
 	return err
 }
 
+// refreshHealth inspects a single managed container to pick up its health-check status,
+// restart count and last-termination details, similar to how Kubelet persists restart
+// counts across GetPodStatus calls. It's called without c's lock held - the inspect
+// RPC runs unlocked, and the lock is only retaken to store the result.
+func (c *DockerScheduler) refreshHealth(t *demand.Task, id string) {
+	inspected, err := c.client.ContainerInspect(context.Background(), id)
+	if err != nil {
+		log.Errorf("Couldn't inspect container %s for task %s: %v", id, t.Name, err)
+		return
+	}
+
+	finishedAt, parseErr := time.Parse(time.RFC3339Nano, inspected.State.FinishedAt)
+
+	c.Lock()
+	cc, ok := c.taskContainers[t.Name][id]
+	if !ok {
+		// The container was removed from our state (e.g. by a "destroy" event)
+		// while we were inspecting it.
+		c.Unlock()
+		return
+	}
+
+	previousHealth := cc.health
+	if inspected.State.Health != nil {
+		cc.health = inspected.State.Health.Status
+	}
+	newHealth := cc.health
+
+	cc.restartCount = inspected.RestartCount
+	c.restartCounts[t.Name+"/"+id] = cc.restartCount
+
+	if inspected.State.ExitCode != 0 || (parseErr == nil && !finishedAt.IsZero()) {
+		cc.lastTermination = &termination{
+			exitCode:   inspected.State.ExitCode,
+			reason:     inspected.State.Error,
+			finishedAt: finishedAt,
+		}
+	}
+	c.Unlock()
+
+	go c.saveRestartCounts()
+
+	// A healthy->unhealthy transition is worth replacing on its own, even when
+	// total demand for this task hasn't changed. Compare the locals captured
+	// above rather than cc.health directly - cc is shared and other goroutines
+	// (handleEvent, other refreshHealth calls) can mutate it the moment we
+	// release the lock.
+	if previousHealth == "healthy" && newHealth == "unhealthy" {
+		log.Infof("Task %s container %s turned unhealthy, replacing it", t.Name, id)
+		go c.replaceUnhealthy(t, id)
+	}
+}
+
+// replaceUnhealthy stops and removes a single unhealthy container and starts a
+// replacement, keeping the task's Requested count unchanged.
+func (c *DockerScheduler) replaceUnhealthy(t *demand.Task, id string) {
+	c.Lock()
+	if cc, ok := c.taskContainers[t.Name][id]; ok {
+		cc.state = "stopping"
+	}
+	c.Unlock()
+
+	ctx := context.Background()
+
+	timeout := 1 * time.Second
+	if err := c.client.ContainerStop(ctx, id, &timeout); err != nil {
+		log.Errorf("Couldn't stop unhealthy container %s: %v", id, err)
+		return
+	}
+
+	if err := c.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{RemoveVolumes: true}); err != nil {
+		log.Errorf("Couldn't remove unhealthy container %s: %v", id, err)
+		return
+	}
+
+	c.releaseContainerMemory(t.Name, id)
+
+	if !c.reserveMemory(t.MemoryLimit) {
+		log.Errorf("Not enough memory on the host to replace unhealthy container for task %s", t.Name)
+		return
+	}
+
+	if err := c.runScaleOp(ctx, t.Name, func() error { return c.startTask(ctx, t) }); err != nil {
+		log.Errorf("Couldn't start replacement for unhealthy task %s: %v", t.Name, err)
+	}
+}
+
+// TaskStatus aggregates the health and restart state we've observed for a task,
+// so callers can tell whether its containers are flapping.
+func (c *DockerScheduler) TaskStatus(taskName string) TaskStatus {
+	c.RLock()
+	defer c.RUnlock()
+
+	var ts TaskStatus
+	var latest time.Time
+	for _, cc := range c.taskContainers[taskName] {
+		switch cc.health {
+		case "healthy":
+			ts.Healthy++
+		case "unhealthy":
+			ts.Unhealthy++
+		}
+
+		ts.RestartCount += cc.restartCount
+		if cc.lastTermination != nil && !cc.lastTermination.finishedAt.Before(latest) {
+			latest = cc.lastTermination.finishedAt
+			ts.LastExitCode = cc.lastTermination.exitCode
+		}
+	}
+
+	return ts
+}
+
 // Cleanup gives the scheduler an opportunity to stop anything that needs to be stopped
 func (c *DockerScheduler) Cleanup() error {
+	close(c.closed)
 	return nil
 }
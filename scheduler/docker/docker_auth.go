@@ -0,0 +1,302 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// authCacheTTL bounds how long we'll reuse a credential before asking the
+// provider for a fresh one. It's deliberately conservative: short-lived
+// tokens (ECR, GCR) are typically valid for much longer than this.
+const authCacheTTL = 10 * time.Minute
+
+// RegistryAuthProvider resolves the credentials needed to pull an image.
+// Returning a zero types.AuthConfig with a nil error means "pull anonymously".
+type RegistryAuthProvider interface {
+	AuthForImage(ctx context.Context, image string) (types.AuthConfig, error)
+}
+
+// NewRegistryAuthProvider returns the default provider chain: ECR, GCR and
+// GHCR token exchange for their respective registries, falling back to
+// ~/.docker/config.json (including credHelpers/credsStore) for everything else.
+func NewRegistryAuthProvider() RegistryAuthProvider {
+	return &chainAuthProvider{
+		providers: []RegistryAuthProvider{
+			&ecrAuthProvider{},
+			&gcrAuthProvider{},
+			&ghcrAuthProvider{},
+			&dockerConfigAuthProvider{},
+		},
+		cache: make(map[string]cachedAuth),
+	}
+}
+
+type cachedAuth struct {
+	auth    types.AuthConfig
+	expires time.Time
+}
+
+// chainAuthProvider tries each provider in turn for the image's registry and
+// caches whichever one succeeds, keyed by registry host, until it expires.
+type chainAuthProvider struct {
+	providers []RegistryAuthProvider
+
+	mu    sync.Mutex
+	cache map[string]cachedAuth
+}
+
+func (p *chainAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	registry := registryHost(image)
+
+	p.mu.Lock()
+	if cached, ok := p.cache[registry]; ok && time.Now().Before(cached.expires) {
+		p.mu.Unlock()
+		return cached.auth, nil
+	}
+	p.mu.Unlock()
+
+	var lastErr error
+	for _, provider := range p.providers {
+		auth, err := provider.AuthForImage(ctx, image)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		p.mu.Lock()
+		p.cache[registry] = cachedAuth{auth: auth, expires: time.Now().Add(authCacheTTL)}
+		p.mu.Unlock()
+
+		return auth, nil
+	}
+
+	return types.AuthConfig{}, lastErr
+}
+
+// registryHost picks the registry out of an image reference, including one
+// pinned by digest (image@sha256:...), following the same "first path segment
+// looks like a host" heuristic Docker itself uses.
+func registryHost(image string) string {
+	name := image
+	if at := strings.IndexByte(name, '@'); at != -1 {
+		name = name[:at]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 1 {
+		return "docker.io"
+	}
+
+	first := parts[0]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first
+	}
+
+	return "docker.io"
+}
+
+// encodeAuth turns credentials into the base64-encoded JSON ImagePull expects
+// in its X-Registry-Auth header.
+func encodeAuth(auth types.AuthConfig) (string, error) {
+	buf, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(buf), nil
+}
+
+// ecrAuthProvider exchanges AWS credentials for a short-lived ECR password via
+// the aws CLI, for images hosted on <account>.dkr.ecr.<region>.amazonaws.com.
+type ecrAuthProvider struct{}
+
+var ecrHostPattern = regexp.MustCompile(`^\d+\.dkr\.ecr\.([\w-]+)\.amazonaws\.com$`)
+
+func (p *ecrAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	registry := registryHost(image)
+	m := ecrHostPattern.FindStringSubmatch(registry)
+	if m == nil {
+		return types.AuthConfig{}, fmt.Errorf("%s is not an ECR registry", registry)
+	}
+
+	out, err := exec.CommandContext(ctx, "aws", "ecr", "get-login-password", "--region", m[1]).Output()
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("aws ecr get-login-password: %v", err)
+	}
+
+	return types.AuthConfig{
+		Username:      "AWS",
+		Password:      strings.TrimSpace(string(out)),
+		ServerAddress: registry,
+	}, nil
+}
+
+// gcrAuthProvider exchanges the caller's gcloud credentials for a bearer
+// token, for images hosted on a registry whose host ends in gcr.io.
+type gcrAuthProvider struct{}
+
+func (p *gcrAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	registry := registryHost(image)
+	if !strings.HasSuffix(registry, "gcr.io") {
+		return types.AuthConfig{}, fmt.Errorf("%s is not a GCR registry", registry)
+	}
+
+	out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("gcloud auth print-access-token: %v", err)
+	}
+
+	return types.AuthConfig{
+		Username:      "oauth2accesstoken",
+		Password:      strings.TrimSpace(string(out)),
+		ServerAddress: registry,
+	}, nil
+}
+
+// ghcrAuthProvider uses a GITHUB_TOKEN as a bearer token for ghcr.io.
+type ghcrAuthProvider struct{}
+
+func (p *ghcrAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	registry := registryHost(image)
+	if registry != "ghcr.io" {
+		return types.AuthConfig{}, fmt.Errorf("%s is not GHCR", registry)
+	}
+
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return types.AuthConfig{}, fmt.Errorf("GITHUB_TOKEN is not set")
+	}
+
+	return types.AuthConfig{
+		Username:      "oauth2",
+		Password:      token,
+		ServerAddress: registry,
+	}, nil
+}
+
+// dockerConfigAuthProvider reads ~/.docker/config.json, including shelling out
+// to docker-credential-* helpers for credHelpers/credsStore entries, the same
+// way the docker CLI itself resolves credentials. Docker Hub is looked up
+// under both "docker.io" and its legacy index URL, since that's where
+// docker login actually stores Hub credentials.
+type dockerConfigAuthProvider struct{}
+
+type dockerConfigFile struct {
+	Auths       map[string]dockerConfigAuthEntry `json:"auths"`
+	CredsStore  string                           `json:"credsStore"`
+	CredHelpers map[string]string                `json:"credHelpers"`
+}
+
+type dockerConfigAuthEntry struct {
+	Auth string `json:"auth"`
+}
+
+// dockerHubIndexURL is the address docker login / config.json actually keys
+// Docker Hub credentials under, rather than the "docker.io" host registryHost
+// resolves images to.
+const dockerHubIndexURL = "https://index.docker.io/v1/"
+
+// configKeys returns the config.json keys (for auths/credHelpers) that might
+// hold credentials for registry, widened to cover Docker Hub's legacy address.
+func configKeys(registry string) []string {
+	if registry == "docker.io" {
+		return []string{registry, dockerHubIndexURL}
+	}
+	return []string{registry}
+}
+
+func (p *dockerConfigAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	registry := registryHost(image)
+	keys := configKeys(registry)
+
+	cfg, err := loadDockerConfig()
+	if err != nil {
+		return types.AuthConfig{}, err
+	}
+
+	for _, key := range keys {
+		if helper, ok := cfg.CredHelpers[key]; ok {
+			return credHelperAuth(ctx, helper, key)
+		}
+	}
+
+	if cfg.CredsStore != "" {
+		for _, key := range keys {
+			if auth, err := credHelperAuth(ctx, cfg.CredsStore, key); err == nil {
+				return auth, nil
+			}
+		}
+	}
+
+	for _, key := range keys {
+		entry, ok := cfg.Auths[key]
+		if !ok || entry.Auth == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return types.AuthConfig{}, fmt.Errorf("malformed auth entry for %s: %v", key, err)
+		}
+
+		user, pass, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return types.AuthConfig{}, fmt.Errorf("malformed auth entry for %s", key)
+		}
+
+		return types.AuthConfig{Username: user, Password: pass, ServerAddress: registry}, nil
+	}
+
+	return types.AuthConfig{}, fmt.Errorf("no credentials configured for registry %s", registry)
+}
+
+func loadDockerConfig() (*dockerConfigFile, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find home directory: %v", err)
+	}
+
+	data, err := os.ReadFile(home + "/.docker/config.json")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read docker config: %v", err)
+	}
+
+	var cfg dockerConfigFile
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("couldn't parse docker config: %v", err)
+	}
+
+	return &cfg, nil
+}
+
+// credHelperAuth shells out to a docker-credential-<helper> binary, the same
+// protocol the docker CLI uses to talk to credsStore/credHelpers entries.
+func credHelperAuth(ctx context.Context, helper, registry string) (types.AuthConfig, error) {
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return types.AuthConfig{}, fmt.Errorf("docker-credential-%s get: %v", helper, err)
+	}
+
+	var resp struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+	if err = json.Unmarshal(out, &resp); err != nil {
+		return types.AuthConfig{}, fmt.Errorf("docker-credential-%s get: %v", helper, err)
+	}
+
+	return types.AuthConfig{Username: resp.Username, Password: resp.Secret, ServerAddress: registry}, nil
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestRegistryHost(t *testing.T) {
+	cases := []struct {
+		image string
+		want  string
+	}{
+		{"ubuntu", "docker.io"},
+		{"ubuntu:latest", "docker.io"},
+		{"myuser/myimage", "docker.io"},
+		{"ubuntu@sha256:abcdef", "docker.io"},
+		{"gcr.io/my-project/my-image", "gcr.io"},
+		{"ghcr.io/my-org/my-image:tag", "ghcr.io"},
+		{"localhost:5000/my-image", "localhost:5000"},
+		{"123456789012.dkr.ecr.us-east-1.amazonaws.com/repo:tag", "123456789012.dkr.ecr.us-east-1.amazonaws.com"},
+	}
+
+	for _, c := range cases {
+		if got := registryHost(c.image); got != c.want {
+			t.Errorf("registryHost(%q) = %q, want %q", c.image, got, c.want)
+		}
+	}
+}
+
+func TestConfigKeys(t *testing.T) {
+	if got := configKeys("docker.io"); len(got) != 2 || got[0] != "docker.io" || got[1] != dockerHubIndexURL {
+		t.Errorf("configKeys(docker.io) = %v, want [docker.io %s]", got, dockerHubIndexURL)
+	}
+
+	if got := configKeys("gcr.io"); len(got) != 1 || got[0] != "gcr.io" {
+		t.Errorf("configKeys(gcr.io) = %v, want [gcr.io]", got)
+	}
+}
+
+type fakeAuthProvider struct {
+	calls int
+	auth  types.AuthConfig
+	err   error
+}
+
+func (p *fakeAuthProvider) AuthForImage(ctx context.Context, image string) (types.AuthConfig, error) {
+	p.calls++
+	return p.auth, p.err
+}
+
+func TestChainAuthProviderCachesSuccess(t *testing.T) {
+	fake := &fakeAuthProvider{auth: types.AuthConfig{Username: "user", Password: "pass"}}
+	chain := &chainAuthProvider{
+		providers: []RegistryAuthProvider{fake},
+		cache:     make(map[string]cachedAuth),
+	}
+
+	for i := 0; i < 3; i++ {
+		auth, err := chain.AuthForImage(context.Background(), "myuser/myimage")
+		if err != nil {
+			t.Fatalf("AuthForImage returned error: %v", err)
+		}
+		if auth.Username != "user" {
+			t.Fatalf("AuthForImage returned %+v, want Username=user", auth)
+		}
+	}
+
+	if fake.calls != 1 {
+		t.Errorf("provider was called %d times, want 1 (result should be cached)", fake.calls)
+	}
+}
+
+func TestChainAuthProviderTriesNextOnError(t *testing.T) {
+	failing := &fakeAuthProvider{err: fmt.Errorf("no credentials")}
+	working := &fakeAuthProvider{auth: types.AuthConfig{Username: "fallback"}}
+	chain := &chainAuthProvider{
+		providers: []RegistryAuthProvider{failing, working},
+		cache:     make(map[string]cachedAuth),
+	}
+
+	auth, err := chain.AuthForImage(context.Background(), "myuser/myimage")
+	if err != nil {
+		t.Fatalf("AuthForImage returned error: %v", err)
+	}
+	if auth.Username != "fallback" {
+		t.Errorf("AuthForImage returned %+v, want Username=fallback", auth)
+	}
+}
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// eventLoop subscribes to the Docker events API and updates c.taskContainers
+// incrementally as create/start/die/destroy/health_status events arrive,
+// similar to how the ctop docker connector watches events. It is launched as
+// a goroutine from NewScheduler and runs until c.closed is closed.
+func (c *DockerScheduler) eventLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-c.closed
+		cancel()
+	}()
+
+	eventOpts := types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelMap)),
+	}
+
+	for {
+		msgs, errs := c.client.Events(ctx, eventOpts)
+
+	stream:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case err := <-errs:
+				if err == nil {
+					continue
+				}
+				log.Errorf("Docker event stream error, resyncing and re-subscribing: %v", err)
+				c.resync()
+				break stream
+
+			case msg := <-msgs:
+				c.handleEvent(msg)
+				// Re-subscribing after a stream error starts from here rather
+				// than from now, so events in between aren't silently dropped -
+				// resync() narrows that gap but doesn't close it on its own.
+				eventOpts.Since = time.Unix(0, msg.TimeNano).Format(time.RFC3339Nano)
+			}
+		}
+	}
+}
+
+// handleEvent applies a single Docker event to c.taskContainers. It reads the
+// task name straight from the event's actor attributes so we don't need an
+// extra ContainerInspect round-trip just to find out which task a container
+// belongs to.
+func (c *DockerScheduler) handleEvent(event events.Message) {
+	taskName, present := event.Actor.Attributes[labelMap]
+	if !present {
+		return
+	}
+
+	id := event.Actor.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.taskContainers[taskName]; !ok {
+		// Not a task we're managing (any more) - ignore.
+		return
+	}
+
+	switch event.Action {
+	case "create":
+		// startTask may already have an entry for this container (with its
+		// memory reservation recorded on it) by the time this event arrives -
+		// don't clobber it.
+		if _, ok := c.taskContainers[taskName][id]; !ok {
+			c.taskContainers[taskName][id] = &dockerContainer{state: "created"}
+		}
+
+	case "start":
+		if cc, ok := c.taskContainers[taskName][id]; ok {
+			cc.state = "starting"
+		} else {
+			c.taskContainers[taskName][id] = &dockerContainer{state: "starting"}
+		}
+		c.needsRefresh[taskName+"/"+id] = true
+
+	case "die":
+		if cc, ok := c.taskContainers[taskName][id]; ok {
+			cc.state = "exited"
+			if !cc.autoRestarts {
+				// It died on its own rather than through stopTask or
+				// replaceUnhealthy, so nothing else will release its
+				// reservation. A restart-policy container keeps its
+				// reservation - Docker restarts the same container itself,
+				// there's no new create/start to reserve against.
+				c.releaseReservation(cc)
+			}
+		}
+
+	case "destroy":
+		if cc, ok := c.taskContainers[taskName][id]; ok {
+			c.releaseReservation(cc)
+		}
+		delete(c.taskContainers[taskName], id)
+		delete(c.needsRefresh, taskName+"/"+id)
+		delete(c.restartCounts, taskName+"/"+id)
+		go c.saveRestartCounts()
+
+	default:
+		if strings.HasPrefix(string(event.Action), "health_status:") {
+			c.needsRefresh[taskName+"/"+id] = true
+		}
+	}
+}
+
+// resync reloads the full container list and reconciles it against
+// c.taskContainers. It's used to recover state after the event stream errors
+// or closes, so no state is lost between the last event we saw and the
+// re-subscribe.
+func (c *DockerScheduler) resync() {
+	listOpts := types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelMap)),
+	}
+	containers, err := c.client.ContainerList(context.Background(), listOpts)
+	if err != nil {
+		log.Errorf("Resync failed to list containers: %v", err)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for i := range containers {
+		taskName, present := containers[i].Labels[labelMap]
+		if !present {
+			continue
+		}
+		if _, ok := c.taskContainers[taskName]; !ok {
+			// Not a task we're managing - don't try to manage anything else.
+			continue
+		}
+
+		id := containers[i].ID[:12]
+		cc, ok := c.taskContainers[taskName][id]
+		if !ok {
+			cc = &dockerContainer{}
+			c.taskContainers[taskName][id] = cc
+		}
+		cc.state = statusToState(containers[i].Status)
+		c.needsRefresh[taskName+"/"+id] = true
+	}
+}
@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func newTestScheduler(taskName string) *DockerScheduler {
+	return &DockerScheduler{
+		taskContainers: map[string]map[string]*dockerContainer{taskName: {}},
+		needsRefresh:   make(map[string]bool),
+		restartCounts:  make(map[string]int),
+	}
+}
+
+func testEvent(action events.Action, taskName, id string) events.Message {
+	return events.Message{
+		Action: action,
+		Actor: events.Actor{
+			ID:         id,
+			Attributes: map[string]string{labelMap: taskName},
+		},
+	}
+}
+
+func TestHandleEventCreateStartDestroy(t *testing.T) {
+	c := newTestScheduler("web")
+	id := "abcdefabcdef1234"
+	shortID := id[:12]
+
+	c.handleEvent(testEvent("create", "web", id))
+	cc, ok := c.taskContainers["web"][shortID]
+	if !ok || cc.state != "created" {
+		t.Fatalf("after create: container = %+v, ok = %v, want state=created", cc, ok)
+	}
+
+	c.handleEvent(testEvent("start", "web", id))
+	if cc.state != "starting" {
+		t.Fatalf("after start: state = %q, want starting", cc.state)
+	}
+	if !c.needsRefresh["web/"+shortID] {
+		t.Fatalf("after start: needsRefresh not set for web/%s", shortID)
+	}
+
+	c.restartCounts["web/"+shortID] = 2
+	c.handleEvent(testEvent("destroy", "web", id))
+	if _, ok := c.taskContainers["web"][shortID]; ok {
+		t.Fatalf("after destroy: container still present")
+	}
+	if _, ok := c.restartCounts["web/"+shortID]; ok {
+		t.Fatalf("after destroy: restartCounts entry still present")
+	}
+	if _, ok := c.needsRefresh["web/"+shortID]; ok {
+		t.Fatalf("after destroy: needsRefresh entry still present")
+	}
+}
+
+func TestHandleEventCreateDoesNotClobberExistingEntry(t *testing.T) {
+	c := newTestScheduler("web")
+	id := "abcdefabcdef1234"
+	shortID := id[:12]
+
+	// startTask already recorded this container (with its memory reservation)
+	// before the daemon's own "create" event arrives.
+	c.taskContainers["web"][shortID] = &dockerContainer{state: "created", memoryReserved: 256}
+
+	c.handleEvent(testEvent("create", "web", id))
+
+	cc := c.taskContainers["web"][shortID]
+	if cc.memoryReserved != 256 {
+		t.Fatalf("memoryReserved = %d, want 256 (create event must not clobber the existing entry)", cc.memoryReserved)
+	}
+}
+
+func TestHandleEventDieReleasesMemoryUnlessAutoRestarts(t *testing.T) {
+	id := "abcdefabcdef1234"
+	shortID := id[:12]
+
+	c := newTestScheduler("web")
+	c.hostMemory = 1000
+	c.committedMemory = 256
+	c.taskContainers["web"][shortID] = &dockerContainer{state: "running", memoryReserved: 256}
+
+	c.handleEvent(testEvent("die", "web", id))
+
+	cc := c.taskContainers["web"][shortID]
+	if cc.state != "exited" {
+		t.Fatalf("state = %q, want exited", cc.state)
+	}
+	if cc.memoryReserved != 0 || c.committedMemory != 0 {
+		t.Fatalf("memoryReserved = %d, committedMemory = %d, want both 0", cc.memoryReserved, c.committedMemory)
+	}
+
+	// A restart-policy container keeps its reservation across a die event -
+	// Docker brings the same container back up without a new create/start.
+	c2 := newTestScheduler("web")
+	c2.hostMemory = 1000
+	c2.committedMemory = 256
+	c2.taskContainers["web"][shortID] = &dockerContainer{state: "running", memoryReserved: 256, autoRestarts: true}
+
+	c2.handleEvent(testEvent("die", "web", id))
+
+	cc2 := c2.taskContainers["web"][shortID]
+	if cc2.memoryReserved != 256 || c2.committedMemory != 256 {
+		t.Fatalf("memoryReserved = %d, committedMemory = %d, want both unchanged at 256 for a restart-policy container", cc2.memoryReserved, c2.committedMemory)
+	}
+}
+
+func TestHandleEventUnknownTaskIgnored(t *testing.T) {
+	c := newTestScheduler("web")
+
+	// "other" isn't a task we're managing - handleEvent must not panic or
+	// create an entry for it.
+	c.handleEvent(testEvent("create", "other", "abcdefabcdef1234"))
+
+	if _, ok := c.taskContainers["other"]; ok {
+		t.Fatalf("handleEvent created an entry for an unmanaged task")
+	}
+}
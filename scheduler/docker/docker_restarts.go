@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// defaultRestartCountsPath is where restart counts are persisted when the
+// caller doesn't set one with SetRestartCountsPath.
+const defaultRestartCountsPath = "microscaling-restart-counts.json"
+
+// restartCountsFile is the on-disk format for persisted restart counts.
+type restartCountsFile struct {
+	Counts map[string]int `json:"counts"` // keyed by "taskName/containerID"
+}
+
+// loadRestartCounts reads c.restartCountsPath and populates c.restartCounts.
+// A missing file just means this is the first run, not an error.
+func (c *DockerScheduler) loadRestartCounts() {
+	data, err := os.ReadFile(c.restartCountsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Couldn't load restart counts from %s: %v", c.restartCountsPath, err)
+		}
+		return
+	}
+
+	var f restartCountsFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		log.Errorf("Couldn't parse restart counts at %s: %v", c.restartCountsPath, err)
+		return
+	}
+
+	if f.Counts == nil {
+		// A {} or {"counts":null} file unmarshals to a nil map - refreshHealth
+		// writes into c.restartCounts unconditionally, so it must never be nil.
+		f.Counts = make(map[string]int)
+	}
+
+	c.Lock()
+	c.restartCounts = f.Counts
+	c.Unlock()
+}
+
+// saveRestartCounts writes c.restartCounts to c.restartCountsPath so they
+// survive a scheduler restart. refreshHealth calls this from a fresh
+// goroutine per container, so writes are serialized through restartCountsMu
+// and land via a temp-file-then-rename so a reader never sees a half-written
+// or interleaved file.
+func (c *DockerScheduler) saveRestartCounts() {
+	c.RLock()
+	counts := make(map[string]int, len(c.restartCounts))
+	for k, v := range c.restartCounts {
+		counts[k] = v
+	}
+	path := c.restartCountsPath
+	c.RUnlock()
+
+	data, err := json.Marshal(restartCountsFile{Counts: counts})
+	if err != nil {
+		log.Errorf("Couldn't serialize restart counts: %v", err)
+		return
+	}
+
+	c.restartCountsMu.Lock()
+	defer c.restartCountsMu.Unlock()
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Errorf("Couldn't persist restart counts to %s: %v", tmp, err)
+		return
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		log.Errorf("Couldn't persist restart counts to %s: %v", path, err)
+	}
+}
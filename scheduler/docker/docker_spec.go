@@ -0,0 +1,185 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	units "github.com/docker/go-units"
+
+	"github.com/microscaling/microscaling/demand"
+)
+
+// validateTask checks a task's resource/restart/healthcheck spec so that a
+// misconfigured task fails at InitScheduler time rather than on every scale-up.
+func validateTask(task *demand.Task) error {
+	if task.MemoryLimit < 0 {
+		return fmt.Errorf("memory limit must not be negative")
+	}
+	if task.CPUShares < 0 || task.CPUQuota < 0 {
+		return fmt.Errorf("CPU shares/quota must not be negative")
+	}
+	if task.PidsLimit < 0 {
+		return fmt.Errorf("pids limit must not be negative")
+	}
+
+	if task.HealthCheck != nil && len(task.HealthCheck.Test) == 0 {
+		return fmt.Errorf("healthcheck requires a Test command")
+	}
+
+	for _, v := range task.Volumes {
+		if v.ContainerPath == "" {
+			return fmt.Errorf("volume mount missing a container path")
+		}
+	}
+
+	for _, u := range task.Ulimits {
+		if u.Name == "" {
+			return fmt.Errorf("ulimit missing a name")
+		}
+	}
+
+	return nil
+}
+
+// taskSpec builds the container.Config and container.HostConfig for a task,
+// plumbing through resource limits, restart policy, ulimits, volumes and a
+// health check rather than just Image/Cmd/Env/PublishAllPorts/NetworkMode.
+func taskSpec(task *demand.Task) (*container.Config, *container.HostConfig) {
+	labels := map[string]string{
+		labelMap: task.Name,
+	}
+
+	config := &container.Config{
+		Image:        task.Image,
+		Cmd:          strings.Fields(task.Command),
+		AttachStdout: true,
+		AttachStdin:  true,
+		Labels:       labels,
+		Env:          task.Env,
+		Healthcheck:  healthConfig(task.HealthCheck),
+	}
+
+	hostConfig := &container.HostConfig{
+		PublishAllPorts: task.PublishAllPorts,
+		NetworkMode:     container.NetworkMode(task.NetworkMode),
+		Binds:           volumeBinds(task.Volumes),
+		RestartPolicy: container.RestartPolicy{
+			Name:              task.RestartPolicy,
+			MaximumRetryCount: task.RestartMaxRetries,
+		},
+		Resources: container.Resources{
+			CPUShares: task.CPUShares,
+			CPUQuota:  task.CPUQuota,
+			Memory:    task.MemoryLimit,
+			PidsLimit: pidsLimitPtr(task.PidsLimit),
+			Ulimits:   ulimits(task.Ulimits),
+		},
+	}
+
+	return config, hostConfig
+}
+
+func healthConfig(hc *demand.HealthCheck) *container.HealthConfig {
+	if hc == nil {
+		return nil
+	}
+
+	return &container.HealthConfig{
+		Test:     hc.Test,
+		Interval: hc.Interval,
+		Timeout:  hc.Timeout,
+		Retries:  hc.Retries,
+	}
+}
+
+func volumeBinds(volumes []demand.Volume) []string {
+	binds := make([]string, 0, len(volumes))
+	for _, v := range volumes {
+		bind := v.HostPath + ":" + v.ContainerPath
+		if v.ReadOnly {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+	return binds
+}
+
+func pidsLimitPtr(limit int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
+
+func ulimits(limits []demand.Ulimit) []*units.Ulimit {
+	out := make([]*units.Ulimit, 0, len(limits))
+	for _, u := range limits {
+		out = append(out, &units.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	return out
+}
+
+// reserveMemory claims mem bytes against the host's total memory, refusing if
+// it would push committed memory over what the host actually has. A task
+// with no MemoryLimit set, or a host whose capacity we couldn't determine,
+// is never blocked.
+func (c *DockerScheduler) reserveMemory(mem int64) bool {
+	if mem <= 0 || c.hostMemory <= 0 {
+		return true
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.committedMemory+mem > c.hostMemory {
+		return false
+	}
+
+	c.committedMemory += mem
+	return true
+}
+
+// releaseMemory gives back memory reserved by reserveMemory that no container
+// ever claimed, e.g. because its create failed or its scale op never ran.
+func (c *DockerScheduler) releaseMemory(mem int64) {
+	if mem <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.committedMemory -= mem
+	if c.committedMemory < 0 {
+		c.committedMemory = 0
+	}
+}
+
+// releaseReservation gives back the memory a single container reserved and
+// zeroes it out, so a later caller on a different terminal path (a failed
+// start, a die/destroy event, reconciliation in CountAllTasks) can't release
+// the same reservation twice. Must be called with c's write lock already held.
+func (c *DockerScheduler) releaseReservation(cc *dockerContainer) {
+	if cc.memoryReserved == 0 {
+		return
+	}
+
+	c.committedMemory -= cc.memoryReserved
+	if c.committedMemory < 0 {
+		c.committedMemory = 0
+	}
+	cc.memoryReserved = 0
+}
+
+// releaseContainerMemory looks up a container by task name and ID and
+// releases its memory reservation, if any. It's the entry point for callers
+// that don't already hold c's write lock.
+func (c *DockerScheduler) releaseContainerMemory(taskName, id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if cc, ok := c.taskContainers[taskName][id]; ok {
+		c.releaseReservation(cc)
+	}
+}
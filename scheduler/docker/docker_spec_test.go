@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/microscaling/microscaling/demand"
+)
+
+func TestValidateTask(t *testing.T) {
+	cases := []struct {
+		name    string
+		task    *demand.Task
+		wantErr bool
+	}{
+		{"valid", &demand.Task{MemoryLimit: 100}, false},
+		{"negative memory", &demand.Task{MemoryLimit: -1}, true},
+		{"negative cpu shares", &demand.Task{CPUShares: -1}, true},
+		{"negative cpu quota", &demand.Task{CPUQuota: -1}, true},
+		{"negative pids limit", &demand.Task{PidsLimit: -1}, true},
+		{"healthcheck with no test", &demand.Task{HealthCheck: &demand.HealthCheck{}}, true},
+		{"healthcheck with test", &demand.Task{HealthCheck: &demand.HealthCheck{Test: []string{"CMD", "true"}}}, false},
+		{"volume missing container path", &demand.Task{Volumes: []demand.Volume{{HostPath: "/host"}}}, true},
+		{"volume ok", &demand.Task{Volumes: []demand.Volume{{HostPath: "/host", ContainerPath: "/container"}}}, false},
+		{"ulimit missing name", &demand.Task{Ulimits: []demand.Ulimit{{Soft: 1, Hard: 2}}}, true},
+		{"ulimit ok", &demand.Task{Ulimits: []demand.Ulimit{{Name: "nofile", Soft: 1, Hard: 2}}}, false},
+	}
+
+	for _, c := range cases {
+		err := validateTask(c.task)
+		if c.wantErr && err == nil {
+			t.Errorf("%s: validateTask() = nil, want an error", c.name)
+		}
+		if !c.wantErr && err != nil {
+			t.Errorf("%s: validateTask() = %v, want nil", c.name, err)
+		}
+	}
+}
+
+func TestReserveAndReleaseMemory(t *testing.T) {
+	c := &DockerScheduler{hostMemory: 1000}
+
+	if !c.reserveMemory(600) {
+		t.Fatal("reserveMemory(600) = false, want true")
+	}
+	if !c.reserveMemory(300) {
+		t.Fatal("reserveMemory(300) = false, want true")
+	}
+	if c.reserveMemory(200) {
+		t.Fatal("reserveMemory(200) = true, want false (only 100 left)")
+	}
+
+	c.releaseMemory(300)
+	if got := c.committedMemory; got != 600 {
+		t.Fatalf("committedMemory = %d, want 600", got)
+	}
+
+	if !c.reserveMemory(200) {
+		t.Fatal("reserveMemory(200) = false, want true after releasing 300")
+	}
+}
+
+func TestReserveMemoryUnbounded(t *testing.T) {
+	c := &DockerScheduler{} // hostMemory unknown (0)
+
+	if !c.reserveMemory(1 << 40) {
+		t.Fatal("reserveMemory should never refuse when hostMemory is unknown")
+	}
+}
+
+func TestReleaseReservationIsIdempotent(t *testing.T) {
+	c := &DockerScheduler{hostMemory: 1000}
+	c.reserveMemory(400)
+
+	cc := &dockerContainer{memoryReserved: 400}
+
+	c.releaseReservation(cc)
+	if c.committedMemory != 0 {
+		t.Fatalf("committedMemory = %d, want 0 after releasing the only reservation", c.committedMemory)
+	}
+	if cc.memoryReserved != 0 {
+		t.Fatalf("memoryReserved = %d, want 0", cc.memoryReserved)
+	}
+
+	// A second release of the same container must be a no-op, or a
+	// concurrent terminal path (die vs. destroy) would double-count.
+	c.reserveMemory(250) // some unrelated container's reservation
+	c.releaseReservation(cc)
+	if c.committedMemory != 250 {
+		t.Fatalf("committedMemory = %d, want 250 (second release must not touch it)", c.committedMemory)
+	}
+}
+
+func TestReleaseContainerMemory(t *testing.T) {
+	c := &DockerScheduler{
+		hostMemory:     1000,
+		taskContainers: map[string]map[string]*dockerContainer{"web": {"abc123": {memoryReserved: 400}}},
+	}
+	c.committedMemory = 400
+
+	c.releaseContainerMemory("web", "abc123")
+	if c.committedMemory != 0 {
+		t.Fatalf("committedMemory = %d, want 0", c.committedMemory)
+	}
+
+	// Unknown container/task: no panic, no change.
+	c.releaseContainerMemory("web", "nosuchid")
+	c.releaseContainerMemory("nosuchtask", "abc123")
+}